@@ -0,0 +1,25 @@
+// Package connkey identifies connections tracked by pool and pooltest.
+package connkey
+
+import "reflect"
+
+// Key returns the key conn is tracked by. Only connections with an
+// identity, a pointer or a channel, are tracked: equal values such as two
+// int file descriptors would share one entry.
+func Key(conn any) (any, bool) {
+	if conn == nil {
+		return nil, false
+	}
+
+	v := reflect.ValueOf(conn)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Chan, reflect.UnsafePointer:
+		if v.IsNil() {
+			return nil, false
+		}
+
+		return conn, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,121 @@
+package pool
+
+import "time"
+
+// FillPolicy decides how a concurrent initial fill handles failed dials
+type FillPolicy int
+
+const (
+	// FillFailFast stops the fill on the first failed dial, Ready returns its error
+	FillFailFast FillPolicy = iota
+	// FillPartial tolerates failed dials, Ready returns once one connection
+	// is created, or with an error once every slot failed its first dial,
+	// while failed slots are dialed again in background
+	FillPartial
+	// FillRetry dials failed slots again until all of them succeed
+	FillRetry
+)
+
+// defaultFillRetryDelay delay between retries of a failed initial dial
+const defaultFillRetryDelay = 100 * time.Millisecond
+
+// Clock provides timers to the pool, replaced by a fake one in tests. It
+// only times the retries of failed initial dials, the pool has no idle or
+// lifetime timeouts.
+type Clock interface {
+	// After waits for d to elapse and sends the current time
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock backed by package time, used by default
+type SystemClock struct{}
+
+// After calls time.After
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Option configures a pool on creation
+type Option func(*options)
+
+type options struct {
+	concurrentFill bool
+	fillPolicy     FillPolicy
+	fillRetryDelay time.Duration
+
+	maxDialConcurrency int
+	// coalesceDials max dials in flight shared by waiters, 0 when off
+	coalesceDials int
+
+	clock Clock
+
+	trackOwnership bool
+}
+
+func newOptions(opts []Option) options {
+	o := options{fillRetryDelay: defaultFillRetryDelay, clock: SystemClock{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithConcurrentFill dials the initial connections concurrently in
+// background instead of one by one, New returns right away and Ready
+// waits for the fill to complete according to policy
+func WithConcurrentFill(policy FillPolicy) Option {
+	return func(o *options) {
+		o.concurrentFill = true
+		o.fillPolicy = policy
+	}
+}
+
+// WithFillRetryDelay sets the delay between retries of a failed initial
+// dial with FillPartial and FillRetry policies
+func WithFillRetryDelay(d time.Duration) Option {
+	return func(o *options) {
+		o.fillRetryDelay = d
+	}
+}
+
+// WithMaxDialConcurrency limits the number of connections dialed at the
+// same time, n <= 0 means no limit
+func WithMaxDialConcurrency(n int) Option {
+	return func(o *options) {
+		o.maxDialConcurrency = n
+	}
+}
+
+// WithDialCoalescing makes Get callers on an empty pool wait for the next
+// available connection, either put back or newly dialed, instead of each
+// dialing its own. At most n dials, 1 if n <= 0, are in flight for all
+// waiting callers, a new one starts when one completes and callers are
+// still waiting. Dials run in background, they are not bound to the
+// context of a single caller.
+func WithDialCoalescing(n int) Option {
+	return func(o *options) {
+		if n <= 0 {
+			n = 1
+		}
+
+		o.coalesceDials = n
+	}
+}
+
+// WithOwnershipTracking keeps track of checked out connections so that
+// Put and Discard return ErrUnknownConn for a connection not issued by
+// the pool. Every checked out connection must then be given back with Put
+// or Discard, a dropped one stays tracked for the life of the pool.
+func WithOwnershipTracking() Option {
+	return func(o *options) {
+		o.trackOwnership = true
+	}
+}
+
+// WithClock sets the clock timing fill retries, see Clock
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
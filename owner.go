@@ -0,0 +1,58 @@
+package pool
+
+import "github.com/shaelmaar/conn-pool/internal/connkey"
+
+// connState where a connection issued by the pool currently is
+type connState int
+
+const (
+	stateIdle connState = iota
+	stateActive
+)
+
+// setStateLocked records conn state, checked out connections are only
+// recorded with WithOwnershipTracking so that callers dropping them do
+// not grow the map
+func (p *Pool[T]) setStateLocked(conn T, s connState) {
+	k, ok := connkey.Key(conn)
+	if !ok {
+		return
+	}
+
+	if s == stateActive && !p.opts.trackOwnership {
+		delete(p.conns, k)
+		return
+	}
+
+	if p.conns == nil {
+		p.conns = make(map[any]connState)
+	}
+
+	p.conns[k] = s
+}
+
+func (p *Pool[T]) untrackLocked(conn T) {
+	if k, ok := connkey.Key(conn); ok {
+		delete(p.conns, k)
+	}
+}
+
+// checkPutLocked returns an error if conn can not be put back
+func (p *Pool[T]) checkPutLocked(conn T) error {
+	k, ok := connkey.Key(conn)
+	if !ok {
+		return nil
+	}
+
+	s, found := p.conns[k]
+	switch {
+	case !found:
+		if p.opts.trackOwnership {
+			return ErrUnknownConn
+		}
+	case s == stateIdle:
+		return ErrDoublePut
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package pool
 
 import (
+	"container/heap"
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -9,112 +11,834 @@ import (
 var (
 	// ErrClosed is the error resulting if the pool is closed via pool.Close().
 	ErrClosed = errors.New("pool is closed")
+	// ErrPingFailed is the reason passed to OnPingError when Ping returns false.
+	ErrPingFailed = errors.New("ping failed")
+	// ErrUnknownConn is the error resulting if Put is passed a connection
+	// not issued by the pool.
+	ErrUnknownConn = errors.New("connection not issued by pool")
+	// ErrDoublePut is the error resulting if Put is passed a connection
+	// already put back.
+	ErrDoublePut = errors.New("connection already put back")
 )
 
+// Pooler is implemented by Pool, depend on it to swap in a fake pool in tests
+type Pooler[T any] interface {
+	Get() (T, error)
+	GetContext(ctx context.Context) (T, error)
+	GetWithPriority(ctx context.Context, prio int) (T, error)
+	Put(conn T) error
+	Discard(conn T) error
+	Len() int
+	Destroy()
+}
+
+var _ Pooler[any] = (*Pool[any])(nil)
+
 // Pool common connection pool
 type Pool[T any] struct {
 	// New create connection function
 	New func() (T, error)
 	// Ping check connection is ok
 	Ping func(T) bool
+	// NewContext create connection function honoring ctx cancellation,
+	// used instead of New when set
+	NewContext func(ctx context.Context) (T, error)
+	// PingContext check connection is ok, used instead of Ping when set
+	PingContext func(ctx context.Context, conn T) error
+	// OnPingError called with the failure reason before a connection
+	// failing its check is closed
+	OnPingError func(conn T, err error)
 	// Close close connection
 	Close func(T)
-	store chan T
-	mu    sync.Mutex
+
+	mu      sync.Mutex
+	idle    []T
+	waiters waitQueue[T]
+	// waitSeq orders waiters of equal priority by arrival
+	waitSeq uint64
+	// numOpen counts idle, checked out and currently dialing connections
+	numOpen int
+	// filling counts background dials started to satisfy minIdle
+	filling int
+	// dialing counts background dials started for coalesced waiters
+	dialing int
+	// limits as requested, see idleLimitsLocked for the ones in effect
+	maxIdle int
+	maxOpen int
+	minIdle int
+	closed  bool
+	// conns tracks idle connections, and checked out ones until Put or
+	// Discard with WithOwnershipTracking
+	conns map[any]connState
+
+	opts options
+	// dialSem limits concurrent dials when max dial concurrency is set
+	dialSem chan struct{}
+	// ctx is cancelled on Destroy to stop background dials
+	ctx    context.Context
+	cancel context.CancelFunc
+	// ready is closed once the initial fill completes with readyErr
+	ready    chan struct{}
+	readyErr error
+	// initial fill progress, fillPending counts slots not yet counted
+	// toward Ready and fillSlots slots still dialing
+	fillPending int
+	fillSlots   int
+	fillOK      int
+	fillFailed  int
+	fillErr     error
 }
 
 // New create a pool with capacity
-func New[T any](initCap, maxCap int, newFunc func() (T, error)) (*Pool[T], error) {
+func New[T any](initCap, maxCap int, newFunc func() (T, error), opts ...Option) (*Pool[T], error) {
 	if maxCap == 0 || initCap > maxCap {
 		return nil, fmt.Errorf("invalid capacity settings")
 	}
 
-	p := new(Pool[T])
-	p.store = make(chan T, maxCap)
+	p := newPool[T](maxCap, opts)
 
 	if newFunc != nil {
 		p.New = newFunc
 	}
 
-	for i := 0; i < initCap; i++ {
-		conn, err := p.create()
-		if err != nil {
-			return p, err
-		}
+	return p, p.initFill(context.Background(), initCap)
+}
+
+// NewWithContext create a pool with capacity, the initial connections
+// are dialed with ctx
+func NewWithContext[T any](ctx context.Context, initCap, maxCap int, newFunc func(context.Context) (T, error), opts ...Option) (*Pool[T], error) {
+	if maxCap == 0 || initCap > maxCap {
+		return nil, fmt.Errorf("invalid capacity settings")
+	}
+
+	p := newPool[T](maxCap, opts)
+
+	if newFunc != nil {
+		p.NewContext = newFunc
+	}
 
-		p.store <- conn
+	return p, p.initFill(ctx, initCap)
+}
+
+func newPool[T any](maxCap int, opts []Option) *Pool[T] {
+	p := new(Pool[T])
+	p.maxIdle = maxCap
+	p.opts = newOptions(opts)
+	if p.opts.maxDialConcurrency > 0 {
+		p.dialSem = make(chan struct{}, p.opts.maxDialConcurrency)
 	}
 
-	return p, nil
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.ready = make(chan struct{})
+
+	return p
 }
 
 // Len returns current connections in pool
 func (p *Pool[T]) Len() int {
-	return len(p.store)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.idle)
 }
 
-// Get returns a conn form store or create one
-func (p *Pool[T]) Get() (conn T, err error) {
-	if p.store == nil {
-		// pool aleardy destroyed, returns error
-		return conn, ErrClosed
+// Ready waits for the initial fill to complete and returns its error,
+// it returns right away unless the pool is created with WithConcurrentFill
+func (p *Pool[T]) Ready(ctx context.Context) error {
+	if p.ready == nil {
+		return nil
+	}
+
+	select {
+	case <-p.ready:
+		return p.readyErr
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
+// Get returns a conn form store or create one, it should be given back
+// with Put or Discard
+func (p *Pool[T]) Get() (conn T, err error) {
+	return p.GetContext(context.Background())
+}
+
+// GetContext returns a conn form store or create one, waiting for a
+// connection to be released while the pool is at its max open limit
+func (p *Pool[T]) GetContext(ctx context.Context) (conn T, err error) {
+	return p.GetWithPriority(ctx, 0)
+}
+
+// GetWithPriority is like GetContext, waiting callers with a higher prio
+// are served first and callers with the same prio in arrival order
+func (p *Pool[T]) GetWithPriority(ctx context.Context, prio int) (conn T, err error) {
+	var w *waiter[T]
 	for {
-		select {
-		case v := <-p.store:
-			if p.Ping != nil && !p.Ping(v) {
+		if err := ctx.Err(); err != nil {
+			return conn, err
+		}
+
+		p.mu.Lock()
+		if p.closed {
+			// pool aleardy destroyed, returns error
+			p.mu.Unlock()
+			return conn, ErrClosed
+		}
+
+		if len(p.idle) > 0 {
+			v := p.idle[0]
+			p.idle[0] = conn
+			p.idle = p.idle[1:]
+			p.setStateLocked(v, stateActive)
+			p.maybeFillLocked()
+			p.mu.Unlock()
+
+			if ok, err := p.check(ctx, v); !ok {
+				if err != nil {
+					return conn, err
+				}
+
 				continue
 			}
 
 			return v, nil
-		default:
+		}
+
+		if p.opts.coalesceDials == 0 && (p.maxOpen <= 0 || p.numOpen < p.maxOpen) {
 			// pool is empty, returns new connection
-			return p.create()
+			p.numOpen++
+			p.mu.Unlock()
+
+			return p.dial(ctx)
+		}
+
+		// max open reached or coalescing dials, wait for a connection
+		// to be put back or dialed
+		if w == nil {
+			// keep the place in line when waiting again after a failed ping
+			p.waitSeq++
+			w = &waiter[T]{ch: make(chan waitResult[T], 1), prio: prio, seq: p.waitSeq}
+		}
+
+		heap.Push(&p.waiters, w)
+		p.dialForWaitersLocked()
+		p.mu.Unlock()
+
+		select {
+		case r := <-w.ch:
+			if r.err != nil {
+				return conn, r.err
+			}
+
+			if r.dial {
+				return p.dial(ctx)
+			}
+
+			if ok, err := p.check(ctx, r.conn); !ok {
+				if err != nil {
+					return conn, err
+				}
+
+				continue
+			}
+
+			return r.conn, nil
+		case <-ctx.Done():
+			p.mu.Lock()
+			removed := p.removeWaiterLocked(w)
+			p.mu.Unlock()
+
+			if !removed {
+				// a result was handed over concurrently, give it back
+				p.reclaim(<-w.ch)
+			}
+
+			return conn, ctx.Err()
 		}
 	}
 }
 
-// Put set back conn into store again
-func (p *Pool[T]) Put(conn T) {
-	select {
-	case p.store <- conn:
-		return
-	default:
+// Put set back conn into store again, it returns ErrDoublePut, or
+// ErrUnknownConn with WithOwnershipTracking, and leaves conn untouched if
+// conn is not checked out from the pool. A connection put back twice is
+// only detected until it is checked out again. Only pointer and channel
+// connections are checked, values such as an int file descriptor or a
+// struct are accepted as is.
+func (p *Pool[T]) Put(conn T) error {
+	p.mu.Lock()
+	if err := p.checkPutLocked(conn); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+
+	keep := p.putLocked(conn)
+	p.mu.Unlock()
+
+	if !keep {
 		// pool is full, close passed connection
-		if p.Close != nil {
-			p.Close(conn)
-		}
+		p.closeConn(conn)
+	}
 
-		return
+	return nil
+}
+
+// Discard gives up a checked out connection instead of putting it back,
+// e.g. after an I/O error. conn is closed and its open slot is freed for
+// a new dial. It returns the same errors as Put and leaves conn untouched
+// if conn is not checked out from the pool, a connection not issued by
+// the pool is only detected with WithOwnershipTracking.
+func (p *Pool[T]) Discard(conn T) error {
+	p.mu.Lock()
+	err := p.checkPutLocked(conn)
+	p.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	p.discard(conn)
+
+	return nil
+}
+
+// SetMaxIdle sets the max number of idle connections kept in the pool,
+// surplus idle connections are closed. n <= 0 means no idle connections
+// are kept. The max open limit applies if it is smaller.
+func (p *Pool[T]) SetMaxIdle(n int) {
+	p.mu.Lock()
+	if n < 0 {
+		n = 0
+	}
+
+	p.maxIdle = n
+
+	surplus := p.shrinkLocked()
+	p.maybeFillLocked()
+	p.mu.Unlock()
+
+	for _, v := range surplus {
+		p.closeConn(v)
+	}
+}
+
+// SetMaxOpen sets the max number of open connections, idle and checked
+// out. n <= 0 means no limit. Lowering it closes surplus idle connections
+// and makes Get wait until checked out connections are put back, raising
+// it lets waiting Get callers dial right away.
+func (p *Pool[T]) SetMaxOpen(n int) {
+	p.mu.Lock()
+	if n < 0 {
+		n = 0
+	}
+
+	p.maxOpen = n
+
+	surplus := p.shrinkLocked()
+	p.releaseLocked()
+	p.maybeFillLocked()
+	p.mu.Unlock()
+
+	for _, v := range surplus {
+		p.closeConn(v)
+	}
+}
+
+// SetMinIdle sets the min number of idle connections, missing ones are
+// dialed in background. The max idle limit applies if it is smaller.
+func (p *Pool[T]) SetMinIdle(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if n < 0 {
+		n = 0
 	}
+
+	p.minIdle = n
+	p.maybeFillLocked()
 }
 
 // Destroy clear all connections
 func (p *Pool[T]) Destroy() {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 
-	if p.store == nil {
+	if p.closed {
 		// pool aleardy destroyed
+		p.mu.Unlock()
 		return
 	}
 
-	close(p.store)
+	p.closed = true
+	if p.cancel != nil {
+		p.cancel()
+	}
+
+	idle := p.idle
+	p.idle = nil
+	p.numOpen -= len(idle)
+
+	for _, v := range idle {
+		p.untrackLocked(v)
+	}
+
+	for _, w := range p.waiters {
+		w.index = -1
+		w.ch <- waitResult[T]{err: ErrClosed}
+	}
+
+	p.waiters = nil
+	p.mu.Unlock()
+
+	for _, v := range idle {
+		p.closeConn(v)
+	}
+}
+
+func (p *Pool[T]) initFill(ctx context.Context, initCap int) error {
+	if p.opts.concurrentFill {
+		p.startConcurrentFill(ctx, initCap)
+		return nil
+	}
+
+	var err error
+	for i := 0; i < initCap; i++ {
+		var conn T
+		conn, err = p.create(ctx)
+		if err != nil {
+			break
+		}
+
+		p.idle = append(p.idle, conn)
+		p.numOpen++
+		p.setStateLocked(conn, stateIdle)
+	}
+
+	p.readyErr = err
+	close(p.ready)
+
+	return err
+}
+
+// startConcurrentFill dials initCap connections in background, the fill
+// is stopped when ctx is done or the pool is destroyed
+func (p *Pool[T]) startConcurrentFill(ctx context.Context, initCap int) {
+	if initCap == 0 {
+		close(p.ready)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-p.ctx.Done():
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	p.mu.Lock()
+	p.numOpen += initCap
+	p.fillPending = initCap
+	p.fillSlots = initCap
+	p.mu.Unlock()
+
+	for i := 0; i < initCap; i++ {
+		go p.fillSlot(ctx, cancel)
+	}
+}
+
+// fillSlot dials one initial connection according to the fill policy,
+// with FillPartial and FillRetry a failed dial is tried again until it
+// succeeds or ctx is done
+func (p *Pool[T]) fillSlot(ctx context.Context, cancel context.CancelFunc) {
+	// decided is set once the slot counts toward Ready, FillRetry only
+	// decides on success while the others decide on the first attempt
+	decided := false
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-p.opts.clock.After(p.opts.fillRetryDelay):
+			case <-ctx.Done():
+			}
+		}
+
+		var conn T
+		err := ctx.Err()
+		if err == nil {
+			conn, err = p.create(ctx)
+		}
+
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+
+		retry := err != nil && ctx.Err() == nil && p.opts.fillPolicy != FillFailFast
+
+		p.mu.Lock()
+		if err == nil {
+			p.fillOK++
+		}
+
+		if !decided && (!retry || p.opts.fillPolicy == FillPartial) {
+			decided = true
+			p.fillPending--
+			if err != nil {
+				p.fillFailed++
+				if p.fillErr == nil {
+					p.fillErr = err
+				}
+			}
+		}
+
+		keep := true
+		if err == nil {
+			keep = p.putLocked(conn)
+		} else if !retry {
+			p.numOpen--
+			p.releaseLocked()
+		}
+
+		if !retry {
+			p.fillSlots--
+		}
+
+		p.fillDoneLocked()
+		slots := p.fillSlots
+		p.mu.Unlock()
 
-	for v := range p.store {
-		if p.Close != nil {
-			p.Close(v)
+		if !keep {
+			p.closeConn(conn)
 		}
+
+		if retry {
+			continue
+		}
+
+		if slots == 0 || (err != nil && p.opts.fillPolicy == FillFailFast) {
+			cancel()
+		}
+
+		return
+	}
+}
+
+// fillDoneLocked closes ready once the fill policy is satisfied
+func (p *Pool[T]) fillDoneLocked() {
+	select {
+	case <-p.ready:
+		return
+	default:
 	}
 
-	p.store = nil
+	switch {
+	case p.opts.fillPolicy == FillPartial && p.fillOK > 0:
+		p.readyErr = nil
+	case p.fillErr != nil && p.opts.fillPolicy != FillPartial:
+		p.readyErr = p.fillErr
+	case p.fillPending > 0:
+		return
+	case p.fillFailed > 0:
+		p.readyErr = fmt.Errorf("all %d initial connections failed: %w", p.fillFailed, p.fillErr)
+	}
+
+	close(p.ready)
 }
 
-func (p *Pool[T]) create() (conn T, err error) {
+func (p *Pool[T]) create(ctx context.Context) (conn T, err error) {
+	if p.dialSem != nil {
+		select {
+		case p.dialSem <- struct{}{}:
+			defer func() { <-p.dialSem }()
+		case <-ctx.Done():
+			return conn, ctx.Err()
+		}
+	}
+
+	if p.NewContext != nil {
+		return p.NewContext(ctx)
+	}
+
 	if p.New == nil {
 		return conn, fmt.Errorf("Pool.New is nil, can not create connection")
 	}
 
 	return p.New()
 }
+
+func (p *Pool[T]) ping(ctx context.Context, conn T) error {
+	if p.PingContext != nil {
+		return p.PingContext(ctx, conn)
+	}
+
+	if p.Ping != nil && !p.Ping(conn) {
+		return ErrPingFailed
+	}
+
+	return nil
+}
+
+// check pings conn, a failing connection is reported and discarded. When
+// ctx is done the ping failure is not blamed on conn, it is put back and
+// ctx error returned.
+func (p *Pool[T]) check(ctx context.Context, conn T) (bool, error) {
+	err := p.ping(ctx, conn)
+	if err == nil {
+		return true, nil
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		p.mu.Lock()
+		keep := p.putLocked(conn)
+		p.mu.Unlock()
+
+		if !keep {
+			p.closeConn(conn)
+		}
+
+		return false, ctxErr
+	}
+
+	if p.OnPingError != nil {
+		p.OnPingError(conn, err)
+	}
+
+	p.discard(conn)
+
+	return false, nil
+}
+
+// dial creates a connection for an already reserved open slot
+func (p *Pool[T]) dial(ctx context.Context) (T, error) {
+	conn, err := p.create(ctx)
+
+	p.mu.Lock()
+	if err != nil {
+		p.numOpen--
+		p.releaseLocked()
+	} else {
+		p.setStateLocked(conn, stateActive)
+	}
+	p.mu.Unlock()
+
+	return conn, err
+}
+
+// discard closes a broken connection and frees its open slot
+func (p *Pool[T]) discard(conn T) {
+	p.mu.Lock()
+	p.untrackLocked(conn)
+	p.numOpen--
+	p.releaseLocked()
+	p.maybeFillLocked()
+	p.mu.Unlock()
+
+	p.closeConn(conn)
+}
+
+// reclaim gives back a result handed to a waiter that already gave up
+func (p *Pool[T]) reclaim(r waitResult[T]) {
+	switch {
+	case r.err != nil:
+	case r.dial:
+		p.mu.Lock()
+		p.numOpen--
+		p.releaseLocked()
+		p.mu.Unlock()
+	default:
+		p.Put(r.conn)
+	}
+}
+
+func (p *Pool[T]) closeConn(conn T) {
+	if p.Close != nil {
+		p.Close(conn)
+	}
+}
+
+// putLocked hands conn to a waiter or keeps it idle, it returns false
+// when the caller has to close conn
+func (p *Pool[T]) putLocked(conn T) bool {
+	if p.closed {
+		p.untrackLocked(conn)
+		p.numOpen--
+		return false
+	}
+
+	// close connections above a lowered max open even with callers
+	// waiting, so the limit takes effect under load
+	if p.maxOpen > 0 && p.numOpen > p.maxOpen {
+		p.untrackLocked(conn)
+		p.numOpen--
+		return false
+	}
+
+	if len(p.waiters) > 0 {
+		w := heap.Pop(&p.waiters).(*waiter[T])
+		w.ch <- waitResult[T]{conn: conn}
+		p.setStateLocked(conn, stateActive)
+
+		return true
+	}
+
+	maxIdle, _ := p.idleLimitsLocked()
+	if len(p.idle) >= maxIdle {
+		p.untrackLocked(conn)
+		p.numOpen--
+		return false
+	}
+
+	p.idle = append(p.idle, conn)
+	p.setStateLocked(conn, stateIdle)
+
+	return true
+}
+
+// releaseLocked lets waiters dial while there is room under maxOpen
+func (p *Pool[T]) releaseLocked() {
+	if p.opts.coalesceDials > 0 {
+		p.dialForWaitersLocked()
+		return
+	}
+
+	for len(p.waiters) > 0 && (p.maxOpen <= 0 || p.numOpen < p.maxOpen) {
+		w := heap.Pop(&p.waiters).(*waiter[T])
+		p.numOpen++
+		w.ch <- waitResult[T]{dial: true}
+	}
+}
+
+// dialForWaitersLocked starts background dials for coalesced waiters
+// not yet covered by a dial in flight
+func (p *Pool[T]) dialForWaitersLocked() {
+	if p.opts.coalesceDials == 0 || p.closed || p.ctx == nil {
+		return
+	}
+
+	for p.dialing < len(p.waiters) &&
+		p.dialing < p.opts.coalesceDials &&
+		(p.maxOpen <= 0 || p.numOpen < p.maxOpen) {
+		p.numOpen++
+		p.dialing++
+
+		go p.dialForWaiter()
+	}
+}
+
+// dialForWaiter hands a new connection, or the dial error, to the first
+// waiter, the connection is kept idle if nobody waits anymore
+func (p *Pool[T]) dialForWaiter() {
+	conn, err := p.create(p.ctx)
+
+	p.mu.Lock()
+	p.dialing--
+
+	keep := true
+	if err != nil {
+		p.numOpen--
+		if len(p.waiters) > 0 {
+			w := heap.Pop(&p.waiters).(*waiter[T])
+			w.ch <- waitResult[T]{err: err}
+		}
+	} else {
+		keep = p.putLocked(conn)
+	}
+
+	p.dialForWaitersLocked()
+	p.mu.Unlock()
+
+	if !keep {
+		p.closeConn(conn)
+	}
+}
+
+func (p *Pool[T]) removeWaiterLocked(w *waiter[T]) bool {
+	if w.index < 0 {
+		return false
+	}
+
+	heap.Remove(&p.waiters, w.index)
+
+	return true
+}
+
+// idleLimitsLocked returns the idle limits in effect: max idle capped by
+// max open, min idle capped by max idle
+func (p *Pool[T]) idleLimitsLocked() (maxIdle, minIdle int) {
+	maxIdle = p.maxIdle
+	if p.maxOpen > 0 && maxIdle > p.maxOpen {
+		maxIdle = p.maxOpen
+	}
+
+	minIdle = p.minIdle
+	if minIdle > maxIdle {
+		minIdle = maxIdle
+	}
+
+	return maxIdle, minIdle
+}
+
+// shrinkLocked removes idle connections above maxIdle or maxOpen and
+// returns them to be closed
+func (p *Pool[T]) shrinkLocked() []T {
+	maxIdle, _ := p.idleLimitsLocked()
+	n := len(p.idle) - maxIdle
+	if p.maxOpen > 0 && p.numOpen-p.maxOpen > n {
+		n = p.numOpen - p.maxOpen
+	}
+
+	if n <= 0 {
+		return nil
+	}
+
+	if n > len(p.idle) {
+		n = len(p.idle)
+	}
+
+	surplus := make([]T, n)
+	copy(surplus, p.idle[:n])
+	p.idle = append(p.idle[:0], p.idle[n:]...)
+	p.numOpen -= n
+
+	for _, v := range surplus {
+		p.untrackLocked(v)
+	}
+
+	return surplus
+}
+
+// maybeFillLocked dials in background until minIdle is reached
+func (p *Pool[T]) maybeFillLocked() {
+	if p.closed || p.ctx == nil || (p.New == nil && p.NewContext == nil) {
+		return
+	}
+
+	_, minIdle := p.idleLimitsLocked()
+	for len(p.idle)+p.filling < minIdle && (p.maxOpen <= 0 || p.numOpen < p.maxOpen) {
+		p.numOpen++
+		p.filling++
+
+		go p.fill()
+	}
+}
+
+func (p *Pool[T]) fill() {
+	conn, err := p.create(p.ctx)
+
+	p.mu.Lock()
+	p.filling--
+
+	if err != nil {
+		// give up for now, the next Get will try again
+		p.numOpen--
+		p.releaseLocked()
+		p.mu.Unlock()
+
+		return
+	}
+
+	keep := p.putLocked(conn)
+	p.mu.Unlock()
+
+	if !keep {
+		p.closeConn(conn)
+	}
+}
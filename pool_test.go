@@ -1,9 +1,11 @@
 package pool
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -139,6 +141,721 @@ func TestPool(t *testing.T) {
 	})
 }
 
+func TestPoolResize(t *testing.T) {
+	d := new(fakeDialer)
+	pool, err := New(4, 4, d.New)
+	assert.NoError(t, err)
+	pool.Close = d.Close
+
+	t.Run("shrink max idle", func(t *testing.T) {
+		pool.SetMaxIdle(2)
+		assert.Equal(t, pool.Len(), 2)
+		assert.Equal(t, d.Closed(), 2)
+	})
+
+	t.Run("grow max idle", func(t *testing.T) {
+		pool.SetMaxIdle(3)
+		conns := make([]*fakeConn, 3)
+		for i := range conns {
+			conns[i], err = pool.Get()
+			assert.NoError(t, err)
+		}
+		for _, c := range conns {
+			pool.Put(c)
+		}
+		assert.Equal(t, pool.Len(), 3)
+	})
+
+	t.Run("max open blocks get", func(t *testing.T) {
+		pool.SetMaxOpen(1)
+		assert.Equal(t, pool.Len(), 1)
+
+		c, err := pool.Get()
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = pool.GetContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		done := make(chan *fakeConn)
+		go func() {
+			v, _ := pool.Get()
+			done <- v
+		}()
+		waitForWaiters(t, pool, 1)
+		pool.Put(c)
+		assert.Equal(t, <-done, c)
+		pool.Put(c)
+	})
+
+	t.Run("grow max open releases waiters", func(t *testing.T) {
+		c, err := pool.Get()
+		assert.NoError(t, err)
+
+		done := make(chan error)
+		go func() {
+			_, err := pool.Get()
+			done <- err
+		}()
+		waitForWaiters(t, pool, 1)
+		pool.SetMaxOpen(2)
+		assert.NoError(t, <-done)
+		pool.Put(c)
+	})
+
+	t.Run("min idle fills in background", func(t *testing.T) {
+		pool.SetMaxOpen(0)
+		pool.SetMinIdle(3)
+		assert.Eventually(t, func() bool {
+			return pool.Len() == 3
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("shrink max open under waiters", func(t *testing.T) {
+		d := new(fakeDialer)
+		pool, err := New(0, 4, d.New)
+		assert.NoError(t, err)
+		pool.Close = d.Close
+		pool.SetMaxOpen(4)
+
+		conns := make([]*fakeConn, 4)
+		for i := range conns {
+			conns[i], err = pool.Get()
+			assert.NoError(t, err)
+		}
+
+		served := make(chan *fakeConn, 4)
+		failed := make(chan error, 4)
+		for i := 0; i < 4; i++ {
+			go func() {
+				v, err := pool.Get()
+				if err != nil {
+					failed <- err
+					return
+				}
+				served <- v
+			}()
+		}
+		waitForWaiters(t, pool, 4)
+
+		pool.SetMaxOpen(1)
+		for _, c := range conns {
+			assert.NoError(t, pool.Put(c))
+		}
+		assert.Equal(t, d.Closed(), 3)
+
+		v := <-served
+		pool.mu.Lock()
+		assert.Equal(t, pool.numOpen, 1)
+		assert.Equal(t, pool.waiters.Len(), 3)
+		pool.mu.Unlock()
+
+		assert.NoError(t, pool.Put(v))
+		<-served
+		pool.Destroy()
+		for i := 0; i < 2; i++ {
+			assert.ErrorIs(t, <-failed, ErrClosed)
+		}
+	})
+
+	t.Run("reload lowers then raises limits", func(t *testing.T) {
+		d := new(fakeDialer)
+		pool, err := New(0, 10, d.New)
+		assert.NoError(t, err)
+		pool.Close = d.Close
+		// limits in effect
+		limits := func() (int, int) {
+			pool.mu.Lock()
+			defer pool.mu.Unlock()
+			return pool.idleLimitsLocked()
+		}
+
+		pool.SetMaxOpen(5)
+		pool.SetMaxIdle(10)
+		pool.SetMinIdle(8)
+		maxIdle, minIdle := limits()
+		assert.Equal(t, maxIdle, 5)
+		assert.Equal(t, minIdle, 5)
+		assert.Eventually(t, func() bool {
+			return pool.Len() == 5
+		}, time.Second, time.Millisecond)
+
+		pool.SetMaxOpen(20)
+		maxIdle, minIdle = limits()
+		assert.Equal(t, maxIdle, 10)
+		assert.Equal(t, minIdle, 8)
+		assert.Eventually(t, func() bool {
+			return pool.Len() == 8
+		}, time.Second, time.Millisecond)
+
+		pool.SetMaxOpen(2)
+		assert.Equal(t, pool.Len(), 2)
+		pool.Destroy()
+	})
+
+	t.Run("destroy wakes waiters", func(t *testing.T) {
+		pool.SetMinIdle(0)
+		pool.SetMaxOpen(pool.Len())
+		for pool.Len() > 0 {
+			_, err := pool.Get()
+			assert.NoError(t, err)
+		}
+
+		done := make(chan error)
+		go func() {
+			_, err := pool.Get()
+			done <- err
+		}()
+		waitForWaiters(t, pool, 1)
+		pool.Destroy()
+		assert.ErrorIs(t, <-done, ErrClosed)
+	})
+}
+
+func TestPoolDiscard(t *testing.T) {
+	d := new(fakeDialer)
+	pool, err := New(0, 1, d.New, WithOwnershipTracking())
+	assert.NoError(t, err)
+	pool.Close = d.Close
+	pool.SetMaxOpen(1)
+
+	c, err := pool.Get()
+	assert.NoError(t, err)
+
+	t.Run("discard frees open slot", func(t *testing.T) {
+		assert.NoError(t, pool.Discard(c))
+		assert.True(t, c.closed)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		v, err := pool.GetContext(ctx)
+		assert.NoError(t, err)
+		assert.NotEqual(t, v, c)
+		assert.NoError(t, pool.Put(v))
+	})
+
+	t.Run("discard wakes waiter", func(t *testing.T) {
+		v, err := pool.Get()
+		assert.NoError(t, err)
+
+		done := make(chan error)
+		go func() {
+			_, err := pool.Get()
+			done <- err
+		}()
+		waitForWaiters(t, pool, 1)
+		assert.NoError(t, pool.Discard(v))
+		assert.NoError(t, <-done)
+	})
+
+	t.Run("discard unknown connection", func(t *testing.T) {
+		assert.ErrorIs(t, pool.Discard(c), ErrUnknownConn)
+	})
+}
+
+func TestPoolContext(t *testing.T) {
+	d := new(fakeDialer)
+	pool, err := NewWithContext(context.Background(), 1, 2, func(ctx context.Context) (*fakeConn, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return d.New()
+	})
+	assert.NoError(t, err)
+	pool.Close = d.Close
+	assert.Equal(t, pool.Len(), 1)
+
+	t.Run("dial honors cancellation", func(t *testing.T) {
+		c, err := pool.Get()
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = pool.GetContext(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+		pool.Put(c)
+	})
+
+	t.Run("ping error reported", func(t *testing.T) {
+		errBroken := fmt.Errorf("broken pipe")
+		var reported error
+		pool.PingContext = func(ctx context.Context, conn *fakeConn) error {
+			if conn.id == 1 {
+				return errBroken
+			}
+			return nil
+		}
+		pool.OnPingError = func(conn *fakeConn, err error) {
+			reported = err
+		}
+
+		c, err := pool.Get()
+		assert.NoError(t, err)
+		assert.NotEqual(t, c.id, 1)
+		assert.ErrorIs(t, reported, errBroken)
+		assert.Equal(t, d.Closed(), 1)
+		assert.NoError(t, pool.Put(c))
+	})
+
+	t.Run("cancelled ctx keeps idle connections", func(t *testing.T) {
+		var reported int
+		pool.PingContext = func(ctx context.Context, conn *fakeConn) error {
+			return ctx.Err()
+		}
+		pool.OnPingError = func(conn *fakeConn, err error) {
+			reported++
+		}
+		n := pool.Len()
+		assert.Greater(t, n, 0)
+		closed := d.Closed()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := pool.GetContext(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, pool.Len(), n)
+		assert.Equal(t, d.Closed(), closed)
+
+		// ctx expiring during the ping puts the connection back
+		ctx, cancel = context.WithCancel(context.Background())
+		pool.PingContext = func(pctx context.Context, conn *fakeConn) error {
+			cancel()
+			return pctx.Err()
+		}
+		_, err = pool.GetContext(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, pool.Len(), n)
+		assert.Equal(t, d.Closed(), closed)
+		assert.Equal(t, reported, 0)
+	})
+
+	t.Run("ping false reported as failed", func(t *testing.T) {
+		var reported error
+		pool.PingContext = nil
+		pool.Ping = func(conn *fakeConn) bool {
+			return false
+		}
+		pool.OnPingError = func(conn *fakeConn, err error) {
+			reported = err
+		}
+
+		_, err := pool.Get()
+		assert.NoError(t, err)
+		assert.ErrorIs(t, reported, ErrPingFailed)
+	})
+}
+
+func TestPoolConcurrentFill(t *testing.T) {
+	errDial := fmt.Errorf("connection refused")
+	// failFirst returns a dial func failing its first n calls
+	failFirst := func(d *fakeDialer, n int) func() (*fakeConn, error) {
+		var mu sync.Mutex
+		return func() (*fakeConn, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if n > 0 {
+				n--
+				return nil, errDial
+			}
+			return d.New()
+		}
+	}
+
+	t.Run("fail fast", func(t *testing.T) {
+		d := new(fakeDialer)
+		pool, err := New(4, 4, failFirst(d, 1), WithConcurrentFill(FillFailFast))
+		assert.NoError(t, err)
+		assert.ErrorIs(t, pool.Ready(context.Background()), errDial)
+		pool.Destroy()
+	})
+
+	t.Run("partial fill", func(t *testing.T) {
+		d := new(fakeDialer)
+		pool, err := New(4, 4, failFirst(d, 2), WithConcurrentFill(FillPartial), WithFillRetryDelay(time.Millisecond))
+		assert.NoError(t, err)
+		assert.NoError(t, pool.Ready(context.Background()))
+		assert.Eventually(t, func() bool {
+			return pool.Len() == 4
+		}, time.Second, time.Millisecond)
+		pool.Destroy()
+	})
+
+	t.Run("partial fill all failed", func(t *testing.T) {
+		d := new(fakeDialer)
+		// every dial fails until the backend is up
+		var mu sync.Mutex
+		var up bool
+		dial := func() (*fakeConn, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !up {
+				return nil, errDial
+			}
+			return d.New()
+		}
+		pool, err := New(2, 2, dial, WithConcurrentFill(FillPartial), WithFillRetryDelay(time.Millisecond))
+		assert.NoError(t, err)
+		assert.ErrorIs(t, pool.Ready(context.Background()), errDial)
+		assert.Equal(t, pool.Len(), 0)
+
+		mu.Lock()
+		up = true
+		mu.Unlock()
+		// failed slots keep filling in background
+		assert.Eventually(t, func() bool {
+			return pool.Len() == 2
+		}, time.Second, time.Millisecond)
+		pool.Destroy()
+	})
+
+	t.Run("retry failed slots", func(t *testing.T) {
+		d := new(fakeDialer)
+		pool, err := New(4, 4, failFirst(d, 3), WithConcurrentFill(FillRetry), WithFillRetryDelay(time.Millisecond))
+		assert.NoError(t, err)
+		assert.NoError(t, pool.Ready(context.Background()))
+		assert.Equal(t, pool.Len(), 4)
+		pool.Destroy()
+	})
+
+	t.Run("retry stopped by destroy", func(t *testing.T) {
+		d := new(fakeDialer)
+		pool, err := New(1, 1, failFirst(d, 1<<30), WithConcurrentFill(FillRetry), WithFillRetryDelay(time.Millisecond))
+		assert.NoError(t, err)
+		pool.Destroy()
+		assert.ErrorIs(t, pool.Ready(context.Background()), context.Canceled)
+	})
+
+	t.Run("ready honors ctx", func(t *testing.T) {
+		block := make(chan struct{})
+		pool, err := NewWithContext(context.Background(), 1, 1, func(ctx context.Context) (*fakeConn, error) {
+			<-block
+			return &fakeConn{}, nil
+		}, WithConcurrentFill(FillFailFast))
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, pool.Ready(ctx), context.DeadlineExceeded)
+		close(block)
+		assert.NoError(t, pool.Ready(context.Background()))
+	})
+}
+
+func TestPoolDialLimit(t *testing.T) {
+	t.Run("max dial concurrency", func(t *testing.T) {
+		var mu sync.Mutex
+		var dialing, peak int
+		d := new(fakeDialer)
+		pool, err := New(0, 10, func() (*fakeConn, error) {
+			mu.Lock()
+			dialing++
+			if dialing > peak {
+				peak = dialing
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			dialing--
+			mu.Unlock()
+			return d.New()
+		}, WithMaxDialConcurrency(2))
+		assert.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := pool.Get()
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, peak, 2)
+	})
+
+	t.Run("coalesced waiters share connections", func(t *testing.T) {
+		gate := make(chan struct{})
+		var mu sync.Mutex
+		var started int
+		// dials counts dials started
+		dials := func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return started
+		}
+		d := new(fakeDialer)
+		pool, err := NewWithContext(context.Background(), 0, 10, func(ctx context.Context) (*fakeConn, error) {
+			mu.Lock()
+			started++
+			mu.Unlock()
+			select {
+			case <-gate:
+				return d.New()
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}, WithDialCoalescing(1))
+		assert.NoError(t, err)
+
+		ids := make(chan int, 10)
+		for i := 0; i < 10; i++ {
+			go func() {
+				c, err := pool.Get()
+				assert.NoError(t, err)
+				ids <- c.id
+				pool.Put(c)
+			}()
+		}
+		waitForWaiters(t, pool, 10)
+		assert.Eventually(t, func() bool {
+			return dials() == 1
+		}, time.Second, time.Millisecond)
+
+		// a single dial serves all waiters in turn
+		gate <- struct{}{}
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, <-ids, 1)
+		}
+
+		// the second wave dial started when the first one completed
+		assert.Eventually(t, func() bool {
+			return dials() == 2
+		}, time.Second, time.Millisecond)
+		pool.mu.Lock()
+		assert.Equal(t, pool.dialing, 1)
+		pool.mu.Unlock()
+		assert.Equal(t, dials(), 2)
+		pool.Destroy()
+	})
+
+	t.Run("coalesced dial error", func(t *testing.T) {
+		errDial := fmt.Errorf("connection refused")
+		pool, err := New(0, 10, func() (*fakeConn, error) {
+			return nil, errDial
+		}, WithDialCoalescing(1))
+		assert.NoError(t, err)
+
+		_, err = pool.Get()
+		assert.ErrorIs(t, err, errDial)
+		pool.Destroy()
+	})
+}
+
+func TestPoolPriority(t *testing.T) {
+	d := new(fakeDialer)
+	pool, err := New(0, 1, d.New)
+	assert.NoError(t, err)
+	pool.SetMaxOpen(1)
+
+	c, err := pool.Get()
+	assert.NoError(t, err)
+
+	// wait enqueues a waiter and blocks until it is in line
+	served := make(chan string, 8)
+	wait := func(name string, prio int) {
+		pool.mu.Lock()
+		n := pool.waiters.Len()
+		pool.mu.Unlock()
+		go func() {
+			v, err := pool.GetWithPriority(context.Background(), prio)
+			assert.NoError(t, err)
+			served <- name
+			pool.Put(v)
+		}()
+		waitForWaiters(t, pool, n+1)
+	}
+
+	wait("batch-1", 0)
+	wait("batch-2", 0)
+	wait("critical-1", 10)
+	wait("batch-3", 0)
+	wait("critical-2", 10)
+
+	t.Run("cancelled waiter leaves the line", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			_, err := pool.GetWithPriority(ctx, 5)
+			done <- err
+		}()
+		waitForWaiters(t, pool, 6)
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+	})
+
+	t.Run("higher priority first then fifo", func(t *testing.T) {
+		pool.Put(c)
+		want := []string{"critical-1", "critical-2", "batch-1", "batch-2", "batch-3"}
+		for _, name := range want {
+			assert.Equal(t, <-served, name)
+		}
+	})
+}
+
+func TestPoolPutOwnership(t *testing.T) {
+	d := new(fakeDialer)
+	pool, err := New(1, 2, d.New, WithOwnershipTracking())
+	assert.NoError(t, err)
+	pool.Close = d.Close
+
+	t.Run("put checked out connection", func(t *testing.T) {
+		c, err := pool.Get()
+		assert.NoError(t, err)
+		assert.NoError(t, pool.Put(c))
+		assert.Equal(t, pool.Len(), 1)
+	})
+
+	t.Run("reject double put", func(t *testing.T) {
+		c, err := pool.Get()
+		assert.NoError(t, err)
+		assert.NoError(t, pool.Put(c))
+		assert.ErrorIs(t, pool.Put(c), ErrDoublePut)
+		assert.Equal(t, pool.Len(), 1)
+	})
+
+	t.Run("reject foreign connection", func(t *testing.T) {
+		foreign := &fakeConn{id: 99}
+		assert.ErrorIs(t, pool.Put(foreign), ErrUnknownConn)
+		assert.Equal(t, pool.Len(), 1)
+		assert.False(t, foreign.closed)
+	})
+
+	t.Run("reject closed connection", func(t *testing.T) {
+		pool.SetMaxIdle(0)
+		assert.Equal(t, pool.Len(), 0)
+		c, err := pool.Get()
+		assert.NoError(t, err)
+		assert.NoError(t, pool.Put(c))
+		assert.True(t, c.closed)
+		assert.ErrorIs(t, pool.Put(c), ErrUnknownConn)
+	})
+
+	t.Run("discard stops tracking", func(t *testing.T) {
+		// tracked counts connections tracked by the pool
+		tracked := func() int {
+			pool.mu.Lock()
+			defer pool.mu.Unlock()
+			return len(pool.conns)
+		}
+
+		c, err := pool.Get()
+		assert.NoError(t, err)
+		n := tracked()
+		assert.NoError(t, pool.Discard(c))
+		assert.Equal(t, tracked(), n-1)
+		assert.ErrorIs(t, pool.Put(c), ErrUnknownConn)
+	})
+
+	t.Run("put after destroy closes connection", func(t *testing.T) {
+		c, err := pool.Get()
+		assert.NoError(t, err)
+		pool.Destroy()
+		assert.NoError(t, pool.Put(c))
+		assert.True(t, c.closed)
+	})
+
+	t.Run("dropped connections are not tracked by default", func(t *testing.T) {
+		d := new(fakeDialer)
+		pool, err := New(2, 2, d.New)
+		assert.NoError(t, err)
+		// tracked counts connections tracked by the pool
+		tracked := func() int {
+			pool.mu.Lock()
+			defer pool.mu.Unlock()
+			return len(pool.conns)
+		}
+		assert.Equal(t, tracked(), 2)
+
+		for i := 0; i < 4; i++ {
+			_, err := pool.Get()
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, tracked(), 0)
+
+		c, err := pool.Get()
+		assert.NoError(t, err)
+		assert.NoError(t, pool.Put(c))
+		assert.ErrorIs(t, pool.Put(c), ErrDoublePut)
+		assert.NoError(t, pool.Put(&fakeConn{id: 99}))
+		assert.Equal(t, tracked(), 2)
+	})
+
+	t.Run("value connections are not checked", func(t *testing.T) {
+		pool, err := New(0, 4, func() (int, error) {
+			return 7, nil
+		})
+		assert.NoError(t, err)
+		pool.SetMaxOpen(2)
+
+		a, err := pool.Get()
+		assert.NoError(t, err)
+		b, err := pool.Get()
+		assert.NoError(t, err)
+		assert.NoError(t, pool.Put(a))
+		assert.NoError(t, pool.Put(b))
+		assert.Equal(t, pool.Len(), 2)
+	})
+
+	t.Run("unhashable dynamic values do not panic", func(t *testing.T) {
+		type sconn struct {
+			f any
+		}
+		pool, err := New(0, 4, func() (any, error) {
+			return sconn{f: []int{1}}, nil
+		})
+		assert.NoError(t, err)
+
+		c, err := pool.Get()
+		assert.NoError(t, err)
+		assert.NoError(t, pool.Put(c))
+		assert.Equal(t, pool.Len(), 1)
+	})
+}
+
+// waitForWaiters blocks until n Get callers wait in pool
+func waitForWaiters[T any](t *testing.T, pool *Pool[T], n int) {
+	assert.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return pool.waiters.Len() == n
+	}, time.Second, time.Millisecond)
+}
+
+// fakeConn in-memory connection used by tests
+type fakeConn struct {
+	id     int
+	closed bool
+}
+
+// fakeDialer creates and closes fakeConn values
+type fakeDialer struct {
+	mu     sync.Mutex
+	dialed int
+	closed int
+}
+
+func (d *fakeDialer) New() (*fakeConn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dialed++
+	return &fakeConn{id: d.dialed}, nil
+}
+
+func (d *fakeDialer) Close(c *fakeConn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c.closed = true
+	d.closed++
+}
+
+func (d *fakeDialer) Closed() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closed
+}
+
 func tcpServer() error {
 	ln, err := net.Listen("tcp4", serverAddr)
 	if err != nil {
@@ -0,0 +1,351 @@
+// Package pooltest provides a fake pool, a fake clock and dial helpers
+// for unit tests of code using pool.
+package pooltest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	pool "github.com/shaelmaar/conn-pool"
+	"github.com/shaelmaar/conn-pool/internal/connkey"
+)
+
+// Pool in-memory pool.Pooler, it hands out idle connections first, in the
+// order they were put back like pool.Pool, and creates new ones with New
+// without any limit. Like pool.Pool created with pool.WithOwnershipTracking
+// it returns pool.ErrUnknownConn and pool.ErrDoublePut from Put and Discard
+// for pointer and channel connections not checked out from it.
+type Pool[T any] struct {
+	// New create connection function
+	New func(ctx context.Context) (T, error)
+	// Close close connection
+	Close func(T)
+
+	mu     sync.Mutex
+	idle   []T
+	gets   int
+	puts   int
+	closed bool
+	// out tracks checked out connections, true, and idle ones, false
+	out map[any]bool
+}
+
+var _ pool.Pooler[any] = (*Pool[any])(nil)
+
+// NewPool create a fake pool
+func NewPool[T any](newFunc func(ctx context.Context) (T, error)) *Pool[T] {
+	return &Pool[T]{New: newFunc}
+}
+
+// Get returns a conn form store or create one
+func (p *Pool[T]) Get() (T, error) {
+	return p.GetContext(context.Background())
+}
+
+// GetContext returns a conn form store or create one
+func (p *Pool[T]) GetContext(ctx context.Context) (conn T, err error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return conn, pool.ErrClosed
+	}
+
+	p.gets++
+	if len(p.idle) > 0 {
+		conn = p.idle[0]
+		p.idle = p.idle[1:]
+		p.trackLocked(conn, true)
+		p.mu.Unlock()
+
+		return conn, nil
+	}
+	p.mu.Unlock()
+
+	if p.New == nil {
+		return conn, fmt.Errorf("Pool.New is nil, can not create connection")
+	}
+
+	conn, err = p.New(ctx)
+	if err != nil {
+		return conn, err
+	}
+
+	p.mu.Lock()
+	p.trackLocked(conn, true)
+	p.mu.Unlock()
+
+	return conn, nil
+}
+
+// GetWithPriority is GetContext, the fake pool never makes callers wait
+func (p *Pool[T]) GetWithPriority(ctx context.Context, prio int) (T, error) {
+	return p.GetContext(ctx)
+}
+
+// Put set back conn into store again, conn is closed if the pool is
+// destroyed
+func (p *Pool[T]) Put(conn T) error {
+	p.mu.Lock()
+	if err := p.checkLocked(conn); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+
+	p.puts++
+	if p.closed {
+		p.untrackLocked(conn)
+		p.mu.Unlock()
+		p.closeConn(conn)
+
+		return nil
+	}
+
+	p.idle = append(p.idle, conn)
+	p.trackLocked(conn, false)
+	p.mu.Unlock()
+
+	return nil
+}
+
+// Discard closes a checked out connection instead of putting it back
+func (p *Pool[T]) Discard(conn T) error {
+	p.mu.Lock()
+	if err := p.checkLocked(conn); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+
+	p.untrackLocked(conn)
+	p.mu.Unlock()
+	p.closeConn(conn)
+
+	return nil
+}
+
+// Len returns current connections in pool
+func (p *Pool[T]) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.idle)
+}
+
+// Destroy clear all connections
+func (p *Pool[T]) Destroy() {
+	p.mu.Lock()
+	idle := p.idle
+	p.closed = true
+	p.idle = nil
+	for _, v := range idle {
+		p.untrackLocked(v)
+	}
+	p.mu.Unlock()
+
+	for _, v := range idle {
+		p.closeConn(v)
+	}
+}
+
+// Gets returns how many times a connection was handed out
+func (p *Pool[T]) Gets() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.gets
+}
+
+// Puts returns how many times a connection was put back
+func (p *Pool[T]) Puts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.puts
+}
+
+// Out returns how many connections are checked out and not given back,
+// only pointer and channel connections are counted
+func (p *Pool[T]) Out() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var n int
+	for _, out := range p.out {
+		if out {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (p *Pool[T]) closeConn(conn T) {
+	if p.Close != nil {
+		p.Close(conn)
+	}
+}
+
+func (p *Pool[T]) trackLocked(conn T, out bool) {
+	k, ok := connkey.Key(conn)
+	if !ok {
+		return
+	}
+
+	if p.out == nil {
+		p.out = make(map[any]bool)
+	}
+
+	p.out[k] = out
+}
+
+func (p *Pool[T]) untrackLocked(conn T) {
+	if k, ok := connkey.Key(conn); ok {
+		delete(p.out, k)
+	}
+}
+
+// checkLocked returns an error if conn is not checked out
+func (p *Pool[T]) checkLocked(conn T) error {
+	k, ok := connkey.Key(conn)
+	if !ok {
+		return nil
+	}
+
+	out, found := p.out[k]
+	switch {
+	case !found:
+		return pool.ErrUnknownConn
+	case !out:
+		return pool.ErrDoublePut
+	}
+
+	return nil
+}
+
+// FakeClock pool.Clock moving only when Advance is called, Now reports
+// the fake time to tests, the pool itself only uses After
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+var _ pool.Clock = (*FakeClock)(nil)
+
+// NewFakeClock create a fake clock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After sends the fake time once Advance moved it by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), ch: ch})
+
+	return ch
+}
+
+// Advance moves the fake time by d and fires the timers due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+
+		t.ch <- c.now
+	}
+
+	c.timers = pending
+}
+
+// Timers returns how many timers wait for the fake time to move
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// FailDial returns a create connection function failing its first n
+// calls with err before calling dial
+func FailDial[T any](n int, err error, dial func(ctx context.Context) (T, error)) func(ctx context.Context) (T, error) {
+	var mu sync.Mutex
+
+	return func(ctx context.Context) (conn T, _ error) {
+		mu.Lock()
+		if n > 0 {
+			n--
+			mu.Unlock()
+			return conn, err
+		}
+		mu.Unlock()
+
+		return dial(ctx)
+	}
+}
+
+// SlowDial returns a create connection function waiting d on clock
+// before calling dial, it gives up when ctx is done
+func SlowDial[T any](clock pool.Clock, d time.Duration, dial func(ctx context.Context) (T, error)) func(ctx context.Context) (T, error) {
+	return func(ctx context.Context) (conn T, err error) {
+		select {
+		case <-clock.After(d):
+			return dial(ctx)
+		case <-ctx.Done():
+			return conn, ctx.Err()
+		}
+	}
+}
+
+// FlapPing returns a check connection function following results in a
+// loop, a false result fails the check with pool.ErrPingFailed
+func FlapPing[T any](results ...bool) func(ctx context.Context, conn T) error {
+	var mu sync.Mutex
+	var i int
+
+	return func(ctx context.Context, conn T) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(results) == 0 {
+			return nil
+		}
+
+		ok := results[i%len(results)]
+		i++
+		if !ok {
+			return pool.ErrPingFailed
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,135 @@
+package pooltest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	pool "github.com/shaelmaar/conn-pool"
+)
+
+type conn struct {
+	id int
+}
+
+func TestPooltest(t *testing.T) {
+	var dialed int
+	dial := func(ctx context.Context) (*conn, error) {
+		dialed++
+		return &conn{id: dialed}, nil
+	}
+
+	t.Run("fake pool", func(t *testing.T) {
+		var p pool.Pooler[*conn] = NewPool(dial)
+		c, err := p.Get()
+		assert.NoError(t, err)
+		assert.NoError(t, p.Put(c))
+		assert.Equal(t, p.Len(), 1)
+
+		v, err := p.GetWithPriority(context.Background(), 10)
+		assert.NoError(t, err)
+		assert.Equal(t, v, c)
+
+		p.Destroy()
+		_, err = p.Get()
+		assert.ErrorIs(t, err, pool.ErrClosed)
+	})
+
+	t.Run("fake pool put validation", func(t *testing.T) {
+		p := NewPool(dial)
+		var closed []*conn
+		p.Close = func(c *conn) {
+			closed = append(closed, c)
+		}
+
+		c, err := p.Get()
+		assert.NoError(t, err)
+		assert.Equal(t, p.Out(), 1)
+		assert.ErrorIs(t, p.Put(&conn{}), pool.ErrUnknownConn)
+		assert.NoError(t, p.Put(c))
+		assert.ErrorIs(t, p.Put(c), pool.ErrDoublePut)
+		assert.Equal(t, p.Len(), 1)
+		assert.Equal(t, p.Out(), 0)
+
+		c, err = p.Get()
+		assert.NoError(t, err)
+		assert.NoError(t, p.Discard(c))
+		assert.ErrorIs(t, p.Put(c), pool.ErrUnknownConn)
+
+		c, err = p.Get()
+		assert.NoError(t, err)
+		p.Destroy()
+		assert.NoError(t, p.Put(c))
+		assert.Equal(t, len(closed), 2)
+		assert.Equal(t, p.Puts(), 2)
+	})
+
+	t.Run("fake pool hands out in put order", func(t *testing.T) {
+		p := NewPool(dial)
+		a, err := p.Get()
+		assert.NoError(t, err)
+		b, err := p.Get()
+		assert.NoError(t, err)
+		assert.NoError(t, p.Put(a))
+		assert.NoError(t, p.Put(b))
+
+		v, err := p.Get()
+		assert.NoError(t, err)
+		assert.Equal(t, v, a)
+	})
+
+	t.Run("fail dial", func(t *testing.T) {
+		errDial := fmt.Errorf("connection refused")
+		f := FailDial(2, errDial, dial)
+		for i := 0; i < 2; i++ {
+			_, err := f(context.Background())
+			assert.ErrorIs(t, err, errDial)
+		}
+		_, err := f(context.Background())
+		assert.NoError(t, err)
+	})
+
+	t.Run("slow dial", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		f := SlowDial(clock, time.Second, dial)
+
+		done := make(chan error)
+		go func() {
+			_, err := f(context.Background())
+			done <- err
+		}()
+		assert.Eventually(t, func() bool {
+			return clock.Timers() == 1
+		}, time.Second, time.Millisecond)
+		clock.Advance(time.Second)
+		assert.NoError(t, <-done)
+	})
+
+	t.Run("flap ping", func(t *testing.T) {
+		f := FlapPing[*conn](true, false)
+		assert.NoError(t, f(context.Background(), nil))
+		assert.ErrorIs(t, f(context.Background(), nil), pool.ErrPingFailed)
+		assert.NoError(t, f(context.Background(), nil))
+	})
+
+	t.Run("fill retry on fake clock", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		errDial := fmt.Errorf("connection refused")
+		p, err := pool.NewWithContext(context.Background(), 1, 1, FailDial(1, errDial, dial),
+			pool.WithConcurrentFill(pool.FillRetry), pool.WithFillRetryDelay(time.Second), pool.WithClock(clock))
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			return clock.Timers() == 1
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, p.Len(), 0)
+
+		clock.Advance(time.Second)
+		assert.NoError(t, p.Ready(context.Background()))
+		assert.Equal(t, p.Len(), 1)
+		p.Destroy()
+	})
+}
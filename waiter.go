@@ -0,0 +1,54 @@
+package pool
+
+// waiter is a Get caller blocked until a connection is available
+type waiter[T any] struct {
+	ch   chan waitResult[T]
+	prio int
+	seq  uint64
+	// index in waitQueue, -1 once popped
+	index int
+}
+
+// waitResult is handed to a waiter: either a returned connection,
+// a reserved slot to dial a new one, or an error
+type waitResult[T any] struct {
+	conn T
+	dial bool
+	err  error
+}
+
+// waitQueue heap of waiters, highest prio first then first come first served
+type waitQueue[T any] []*waiter[T]
+
+func (q waitQueue[T]) Len() int { return len(q) }
+
+func (q waitQueue[T]) Less(i, j int) bool {
+	if q[i].prio != q[j].prio {
+		return q[i].prio > q[j].prio
+	}
+
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue[T]) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue[T]) Push(x any) {
+	w := x.(*waiter[T])
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue[T]) Pop() any {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*q = old[:n-1]
+
+	return w
+}